@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// accessTokenMiddleware rejects requests that don't present a valid
+// Config.AccessHeader token, unless the request path is in
+// Config.AccessAllowedPaths. A token is valid if it matches one of
+// Config.AccessTokens verbatim, or is a well-formed, unexpired HMAC-signed
+// token (see verifyHMACToken).
+func accessTokenMiddleware(config Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		path := string(c.Request().URI().Path())
+		for _, allowed := range config.AccessAllowedPaths {
+			if allowed != "" && path == allowed {
+				return c.Next()
+			}
+		}
+
+		token := c.Get(config.AccessHeader)
+		if token == "" {
+			return fiber.NewError(fiber.StatusUnauthorized, "missing access token")
+		}
+
+		for _, valid := range config.AccessTokens {
+			if valid != "" && subtle.ConstantTimeCompare([]byte(token), []byte(valid)) == 1 {
+				return c.Next()
+			}
+		}
+
+		if config.AccessHMACSecret != "" && verifyHMACToken(config.AccessHMACSecret, token, path) {
+			return c.Next()
+		}
+
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid access token")
+	}
+}
+
+// verifyHMACToken validates a short-lived signed token of the form
+// "<expiresUnix>.<allowedPathsB64>.<signatureHex>", where allowedPathsB64 is
+// a comma-separated list of paths the token is scoped to (empty meaning any
+// path), base64url-encoded so a path containing a "." (e.g. "/gtag.js")
+// can't be confused with the "." segment separator, and signature is
+// HMAC-SHA256(secret, "<expiresUnix>.<allowedPathsB64>"). This lets
+// server-side event forwarders be authenticated with a short-lived token
+// instead of embedding a long-lived AccessTokens entry in a browser.
+func verifyHMACToken(secret, token, path string) bool {
+	parts := strings.SplitN(token, ".", 3)
+	if len(parts) != 3 {
+		return false
+	}
+	expiresUnix, allowedPathsB64, signature := parts[0], parts[1], parts[2]
+
+	expires, err := strconv.ParseInt(expiresUnix, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(expiresUnix + "." + allowedPathsB64))
+	expectedSignature := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(signature), []byte(expectedSignature)) {
+		return false
+	}
+
+	if allowedPathsB64 == "" {
+		return true
+	}
+	allowedPathsRaw, err := base64.RawURLEncoding.DecodeString(allowedPathsB64)
+	if err != nil {
+		return false
+	}
+	for _, allowedPath := range strings.Split(string(allowedPathsRaw), ",") {
+		if allowedPath == path {
+			return true
+		}
+	}
+	return false
+}
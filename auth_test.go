@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func signHMACToken(secret, expiresUnix, allowedPathsB64 string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(expiresUnix + "." + allowedPathsB64))
+	return expiresUnix + "." + allowedPathsB64 + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyHMACTokenValid(t *testing.T) {
+	secret := "s3cr3t"
+	expires := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+	token := signHMACToken(secret, expires, "")
+
+	if !verifyHMACToken(secret, token, "/mp/collect") {
+		t.Fatal("expected valid unscoped token to verify")
+	}
+}
+
+func TestVerifyHMACTokenExpired(t *testing.T) {
+	secret := "s3cr3t"
+	expires := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	token := signHMACToken(secret, expires, "")
+
+	if verifyHMACToken(secret, token, "/mp/collect") {
+		t.Fatal("expected expired token to fail verification")
+	}
+}
+
+func TestVerifyHMACTokenBadSignature(t *testing.T) {
+	secret := "s3cr3t"
+	expires := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+	token := signHMACToken(secret, expires, "") + "tampered"
+
+	if verifyHMACToken(secret, token, "/mp/collect") {
+		t.Fatal("expected tampered token to fail verification")
+	}
+
+	if verifyHMACToken("wrong-secret", signHMACToken(secret, expires, ""), "/mp/collect") {
+		t.Fatal("expected token signed with a different secret to fail verification")
+	}
+}
+
+func TestVerifyHMACTokenScopedPathWithDot(t *testing.T) {
+	secret := "s3cr3t"
+	expires := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+	allowedPathsB64 := base64.RawURLEncoding.EncodeToString([]byte("/gtag.js,/mp/collect"))
+	token := signHMACToken(secret, expires, allowedPathsB64)
+
+	if !verifyHMACToken(secret, token, "/gtag.js") {
+		t.Fatal("expected token scoped to a path containing a dot to verify")
+	}
+	if verifyHMACToken(secret, token, "/other") {
+		t.Fatal("expected token to be rejected for a path outside allowedPaths")
+	}
+}
+
+func TestVerifyHMACTokenMalformed(t *testing.T) {
+	if verifyHMACToken("s3cr3t", "not-a-token", "/mp/collect") {
+		t.Fatal("expected malformed token to fail verification")
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Config holds all runtime configuration for gaxy, populated from
+// environment variables (and, for Sites, StaticRoutes and HostOverrides,
+// optional JSON files).
+type Config struct {
+	Port         string
+	GoogleOrigin string
+	RoutePrefix  string
+
+	InjectParamsFromReqHeaders string
+	SkipParamsFromReqHeaders   string
+
+	// SitesConfigFile points at a JSON file describing the declarative
+	// routing table. When empty, a single default site is derived from
+	// GoogleOrigin/RoutePrefix so existing deployments keep working.
+	SitesConfigFile string
+	Sites           []Site
+
+	StaticRoutes []StaticRoute
+
+	MetricsEnabled  bool
+	MetricsPath     string
+	MetricsAllowIPs []string
+
+	// AccessTokens and AccessHMACSecret gate every route except
+	// AccessAllowedPaths behind Config.AccessHeader. Gating is disabled when
+	// both are empty, to keep existing deployments working unauthenticated.
+	AccessTokens       []string
+	AccessHeader       string
+	AccessAllowedPaths []string
+	AccessHMACSecret   string
+
+	// MeasurementProtocol controls the server-side GA4 Measurement Protocol
+	// endpoint (see measurement_protocol.go). BufferSize of 0 forwards hits
+	// synchronously; a positive size enables the bounded background flusher.
+	MeasurementProtocolEnabled    bool
+	MeasurementProtocolPath       string
+	MeasurementProtocolBufferSize int
+	MeasurementProtocolMaxRetries int
+
+	// HostOverridesConfigFile points at a JSON file of HostOverride entries,
+	// letting one gaxy instance serve distinct GA properties per inbound host.
+	HostOverridesConfigFile string
+	HostOverrides           []HostOverride
+}
+
+// StaticRoute serves a local directory under the gaxy origin, e.g. for a
+// first-party gtag.js bootstrap or a robots.txt shipped alongside the proxy.
+type StaticRoute struct {
+	Prefix string `json:"prefix"`
+	Root   string `json:"root"`
+	Index  string `json:"index,omitempty"`
+	// CacheControl is a max-age in seconds, e.g. "3600".
+	CacheControl string `json:"cacheControl,omitempty"`
+	ByteRange    bool   `json:"byteRange,omitempty"`
+}
+
+// LoadConfig builds a Config from environment variables.
+func LoadConfig() Config {
+	config := Config{
+		Port:         getEnv("PORT", "8080"),
+		GoogleOrigin: getEnv("GOOGLE_ORIGIN", "https://www.google-analytics.com"),
+		RoutePrefix:  getEnv("ROUTE_PREFIX", ""),
+
+		InjectParamsFromReqHeaders: getEnv("INJECT_PARAMS_FROM_REQ_HEADERS", ""),
+		SkipParamsFromReqHeaders:   getEnv("SKIP_PARAMS_FROM_REQ_HEADERS", ""),
+
+		SitesConfigFile: getEnv("SITES_CONFIG_FILE", ""),
+
+		MetricsEnabled: getEnv("METRICS_ENABLED", "false") == "true",
+		MetricsPath:    getEnv("METRICS_PATH", "/metrics"),
+
+		AccessHeader:     getEnv("ACCESS_HEADER", "Cf-Access-Token"),
+		AccessHMACSecret: getEnv("ACCESS_HMAC_SECRET", ""),
+
+		MeasurementProtocolEnabled: getEnv("MEASUREMENT_PROTOCOL_ENABLED", "false") == "true",
+		MeasurementProtocolPath:    getEnv("MEASUREMENT_PROTOCOL_PATH", "/mp/collect"),
+	}
+
+	config.MeasurementProtocolBufferSize = getEnvInt("MEASUREMENT_PROTOCOL_BUFFER_SIZE", 0)
+	config.MeasurementProtocolMaxRetries = getEnvInt("MEASUREMENT_PROTOCOL_MAX_RETRIES", 3)
+
+	if ips := getEnv("METRICS_ALLOW_IPS", ""); ips != "" {
+		config.MetricsAllowIPs = strings.Split(ips, ",")
+	}
+
+	if tokens := getEnv("ACCESS_TOKENS", ""); tokens != "" {
+		config.AccessTokens = strings.Split(tokens, ",")
+	}
+
+	if paths := getEnv("ACCESS_ALLOWED_PATHS", ""); paths != "" {
+		config.AccessAllowedPaths = strings.Split(paths, ",")
+	}
+
+	sites, err := loadSites(config)
+	if err != nil {
+		log.Fatalf("Failed to load sites config: %v", err)
+	}
+	config.Sites = sites
+
+	staticRoutes, err := loadStaticRoutes(getEnv("STATIC_ROUTES_CONFIG_FILE", ""))
+	if err != nil {
+		log.Fatalf("Failed to load static routes config: %v", err)
+	}
+	config.StaticRoutes = staticRoutes
+
+	config.HostOverridesConfigFile = getEnv("HOST_OVERRIDES_CONFIG_FILE", "")
+	hostOverrides, err := loadHostOverrides(config.HostOverridesConfigFile)
+	if err != nil {
+		log.Fatalf("Failed to load host overrides config: %v", err)
+	}
+	config.HostOverrides = hostOverrides
+
+	return config
+}
+
+// loadHostOverrides reads the per-host config override list from a JSON
+// file, if configured. Returns an empty slice when path is empty. Every
+// HostRegex is compiled here, so a malformed one fails at startup instead of
+// silently never matching.
+func loadHostOverrides(path string) ([]HostOverride, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var overrides []HostOverride
+	if err := json.Unmarshal(raw, &overrides); err != nil {
+		return nil, err
+	}
+
+	if err := compileHostOverrides(overrides); err != nil {
+		return nil, err
+	}
+
+	return overrides, nil
+}
+
+// loadStaticRoutes reads the static file serving config from a JSON file, if
+// configured. Returns an empty slice when path is empty.
+func loadStaticRoutes(path string) ([]StaticRoute, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var routes []StaticRoute
+	if err := json.Unmarshal(raw, &routes); err != nil {
+		return nil, err
+	}
+
+	return routes, nil
+}
+
+// loadSites reads the declarative routing table from SitesConfigFile, or
+// falls back to a single site built from the legacy GoogleOrigin/RoutePrefix
+// environment variables. Every Matcher/Transformer regex is compiled here,
+// so a malformed pathRegex/pattern fails at startup instead of on first use.
+func loadSites(config Config) ([]Site, error) {
+	var sites []Site
+
+	if config.SitesConfigFile == "" {
+		sites = defaultSites(config)
+	} else {
+		raw, err := os.ReadFile(config.SitesConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, &sites); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := compileSites(sites); err != nil {
+		return nil, err
+	}
+
+	return sites, nil
+}
+
+// defaultSites reproduces gaxy's original behaviour (a single Google
+// Analytics/Ads upstream, plus the `/g/collect` special case) as config
+// entries, so that deployments without a SitesConfigFile keep working.
+func defaultSites(config Config) []Site {
+	return []Site{
+		{
+			Name: "ga-collect",
+			Matchers: []Matcher{
+				{PathPrefix: "/g/collect"},
+			},
+			Upstreams: []string{"https://www.google-analytics.com"},
+		},
+		{
+			Name: "default",
+			Matchers: []Matcher{
+				{PathPrefix: "/"},
+			},
+			Upstreams: []string{config.GoogleOrigin},
+			Transformers: []Transformer{
+				{Type: "replaceBody", Pattern: `"\+\(a\?a\+"\."\:""\)\+"analytics\.google\.com`, Replacement: "{{host}}"},
+				{Type: "replaceBody", Pattern: `ssl\.google-analytics\.com`, Replacement: "{{host}}"},
+				{Type: "replaceBody", Pattern: `"\+a\+"\.google-analytics\.com`, Replacement: "{{host}}"},
+				{Type: "replaceBody", Pattern: `www\.google-analytics\.com`, Replacement: "{{host}}"},
+				{Type: "replaceBody", Pattern: `google-analytics\.com`, Replacement: "{{host}}"},
+				{Type: "replaceBody", Pattern: `www\.googletagmanager\.com`, Replacement: "{{host}}"},
+				{Type: "replaceBody", Pattern: `googletagmanager\.com`, Replacement: "{{host}}"},
+			},
+		},
+	}
+}
+
+func getEnv(name, fallback string) string {
+	if val, ok := os.LookupEnv(name); ok {
+		return val
+	}
+	return fallback
+}
+
+func getEnvInt(name string, fallback int) int {
+	val, ok := os.LookupEnv(name)
+	if !ok {
+		return fallback
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return fallback
+	}
+	return n
+}
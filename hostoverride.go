@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// HostOverride lets a single gaxy instance serve distinct GA properties and
+// header-injection rules per inbound host, instead of requiring one process
+// per domain. Host may be an exact host or a "*.suffix" wildcard; HostRegex
+// is checked only when no Host pattern matches, per hostOverrideMatcher.match's
+// priority rule: exact host > longest suffix wildcard > regex > default. An
+// entry with Host set is matched by Host alone; its HostRegex is not used.
+type HostOverride struct {
+	Host      string `json:"host,omitempty"`
+	HostRegex string `json:"hostRegex,omitempty"`
+
+	GoogleOrigin               string `json:"googleOrigin,omitempty"`
+	RoutePrefix                string `json:"routePrefix,omitempty"`
+	InjectParamsFromReqHeaders string `json:"injectParamsFromReqHeaders,omitempty"`
+
+	// compiledHostRegex is HostRegex compiled once by compileHostOverrides,
+	// rather than on every newHostOverrideMatcher call.
+	compiledHostRegex *regexp.Regexp
+}
+
+// compile precompiles HostRegex, if set.
+func (o *HostOverride) compile() error {
+	if o.HostRegex == "" {
+		return nil
+	}
+	re, err := regexp.Compile(o.HostRegex)
+	if err != nil {
+		return fmt.Errorf("invalid hostRegex %q: %w", o.HostRegex, err)
+	}
+	o.compiledHostRegex = re
+	return nil
+}
+
+// compileHostOverrides precompiles every HostOverride's HostRegex, so a
+// malformed pattern fails at config load instead of silently never matching.
+func compileHostOverrides(overrides []HostOverride) error {
+	for i := range overrides {
+		if err := overrides[i].compile(); err != nil {
+			return fmt.Errorf("host override %q: %w", overrides[i].Host, err)
+		}
+	}
+	return nil
+}
+
+type hostRegexOverride struct {
+	re       *regexp.Regexp
+	override *HostOverride
+}
+
+// hostOverrideMatcher resolves an inbound host to its HostOverride, if any.
+type hostOverrideMatcher struct {
+	trie    *hostTrie
+	regexes []hostRegexOverride
+}
+
+// newHostOverrideMatcher builds a matcher from a Config.HostOverrides list.
+// overrides must already have gone through compileHostOverrides.
+func newHostOverrideMatcher(overrides []HostOverride) *hostOverrideMatcher {
+	m := &hostOverrideMatcher{trie: newHostTrie()}
+
+	for i := range overrides {
+		o := &overrides[i]
+		switch {
+		case len(o.Host) > 2 && o.Host[:2] == "*.":
+			m.trie.insertWildcard(o.Host[2:], o)
+		case o.Host != "":
+			m.trie.insertExact(o.Host, o)
+		case o.HostRegex != "":
+			m.regexes = append(m.regexes, hostRegexOverride{re: o.compiledHostRegex, override: o})
+		}
+	}
+
+	return m
+}
+
+// match returns the HostOverride for host: exact host wins, then the
+// longest matching suffix wildcard, then the first matching regex, else nil.
+func (m *hostOverrideMatcher) match(host string) *HostOverride {
+	if payload, ok := m.trie.lookup(host); ok {
+		return payload.(*HostOverride)
+	}
+	for _, entry := range m.regexes {
+		if entry.re.MatchString(host) {
+			return entry.override
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import "strings"
+
+// hostTrieNode is one label of a reversed-host trie. exactIdx/wildcardIdx
+// index into hostTrie.payloads, -1 meaning unset.
+type hostTrieNode struct {
+	children    map[string]*hostTrieNode
+	exactIdx    int
+	wildcardIdx int
+}
+
+func newHostTrieNode() *hostTrieNode {
+	return &hostTrieNode{children: map[string]*hostTrieNode{}, exactIdx: -1, wildcardIdx: -1}
+}
+
+// hostTrie resolves a host to the payload registered for the most specific
+// matching pattern: an exact host always wins; otherwise the longest
+// matching "*.suffix" wildcard wins. Keyed on reversed host labels (TLD
+// first) so a lookup costs O(number of labels) regardless of how many
+// patterns are registered.
+type hostTrie struct {
+	root     *hostTrieNode
+	payloads []interface{}
+}
+
+func newHostTrie() *hostTrie {
+	return &hostTrie{root: newHostTrieNode()}
+}
+
+func reversedHostLabels(host string) []string {
+	labels := strings.Split(host, ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return labels
+}
+
+func (t *hostTrie) insert(labels []string, payload interface{}, setter func(*hostTrieNode, int)) {
+	idx := len(t.payloads)
+	t.payloads = append(t.payloads, payload)
+
+	node := t.root
+	for _, label := range labels {
+		child, ok := node.children[label]
+		if !ok {
+			child = newHostTrieNode()
+			node.children[label] = child
+		}
+		node = child
+	}
+	setter(node, idx)
+}
+
+// insertExact registers payload for an exact host match, e.g. "a.example.com".
+func (t *hostTrie) insertExact(host string, payload interface{}) {
+	t.insert(reversedHostLabels(host), payload, func(n *hostTrieNode, idx int) { n.exactIdx = idx })
+}
+
+// insertWildcard registers payload for "*.suffix" (suffix itself, e.g.
+// "example.com", matching "foo.example.com" and any deeper subdomain, but
+// not "example.com" itself).
+func (t *hostTrie) insertWildcard(suffix string, payload interface{}) {
+	t.insert(reversedHostLabels(suffix), payload, func(n *hostTrieNode, idx int) { n.wildcardIdx = idx })
+}
+
+// lookup returns the payload for the most specific match of host: exact
+// beats the longest-suffix wildcard. A wildcard registered for "suffix"
+// only matches proper subdomains of suffix, never suffix itself, so a
+// wildcardIdx is only considered when at least one more label remains
+// after the node it's set on.
+func (t *hostTrie) lookup(host string) (interface{}, bool) {
+	labels := reversedHostLabels(host)
+	node := t.root
+	wildcardIdx := -1
+
+	for i, label := range labels {
+		child, ok := node.children[label]
+		if !ok {
+			node = nil
+			break
+		}
+		if child.wildcardIdx != -1 && i < len(labels)-1 {
+			wildcardIdx = child.wildcardIdx
+		}
+		node = child
+	}
+
+	if node != nil && node.exactIdx != -1 {
+		return t.payloads[node.exactIdx], true
+	}
+	if wildcardIdx != -1 {
+		return t.payloads[wildcardIdx], true
+	}
+	return nil, false
+}
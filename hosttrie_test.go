@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestHostTrieLookupExactBeatsWildcard(t *testing.T) {
+	trie := newHostTrie()
+	trie.insertWildcard("example.com", "wildcard")
+	trie.insertExact("a.example.com", "exact")
+
+	payload, ok := trie.lookup("a.example.com")
+	if !ok || payload != "exact" {
+		t.Fatalf("expected exact match to win, got %v, %v", payload, ok)
+	}
+}
+
+func TestHostTrieLookupWildcardMatchesSubdomain(t *testing.T) {
+	trie := newHostTrie()
+	trie.insertWildcard("example.com", "wildcard")
+
+	payload, ok := trie.lookup("foo.example.com")
+	if !ok || payload != "wildcard" {
+		t.Fatalf("expected wildcard to match subdomain, got %v, %v", payload, ok)
+	}
+
+	payload, ok = trie.lookup("bar.foo.example.com")
+	if !ok || payload != "wildcard" {
+		t.Fatalf("expected wildcard to match deeper subdomain, got %v, %v", payload, ok)
+	}
+}
+
+func TestHostTrieLookupWildcardExcludesApex(t *testing.T) {
+	trie := newHostTrie()
+	trie.insertWildcard("example.com", "wildcard")
+
+	if payload, ok := trie.lookup("example.com"); ok {
+		t.Fatalf("expected wildcard not to match its own suffix, got %v", payload)
+	}
+}
+
+func TestHostTrieLookupLongestWildcardWins(t *testing.T) {
+	trie := newHostTrie()
+	trie.insertWildcard("example.com", "short")
+	trie.insertWildcard("foo.example.com", "long")
+
+	payload, ok := trie.lookup("bar.foo.example.com")
+	if !ok || payload != "long" {
+		t.Fatalf("expected longest matching wildcard to win, got %v, %v", payload, ok)
+	}
+}
+
+func TestHostTrieLookupNoMatch(t *testing.T) {
+	trie := newHostTrie()
+	trie.insertExact("a.example.com", "exact")
+
+	if payload, ok := trie.lookup("b.example.com"); ok {
+		t.Fatalf("expected no match, got %v", payload)
+	}
+}
+
+func TestMatchesHostPattern(t *testing.T) {
+	cases := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "a.example.com", false},
+		{"*.example.com", "a.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "notexample.com", false},
+	}
+
+	for _, c := range cases {
+		if got := matchesHostPattern(c.pattern, c.host); got != c.want {
+			t.Errorf("matchesHostPattern(%q, %q) = %v, want %v", c.pattern, c.host, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+const measurementProtocolUpstream = "https://www.google-analytics.com/mp/collect"
+
+// mpEvent is one GA4 Measurement Protocol event.
+type mpEvent struct {
+	Name   string                 `json:"name"`
+	Params map[string]interface{} `json:"params"`
+}
+
+// mpPayload is a GA4 Measurement Protocol request body, server-to-server.
+// UserID/TimestampMicros/UserProperties/Consent/NonPersonalizedAds are
+// optional top-level fields GA4 MP recognizes; they're passed through
+// verbatim to the upstream request rather than dropped.
+type mpPayload struct {
+	MeasurementID string `json:"measurement_id"`
+	APISecret     string `json:"api_secret"`
+	ClientID      string `json:"client_id"`
+
+	UserID             string                 `json:"user_id,omitempty"`
+	TimestampMicros    int64                  `json:"timestamp_micros,omitempty"`
+	UserProperties     map[string]interface{} `json:"user_properties,omitempty"`
+	Consent            map[string]interface{} `json:"consent,omitempty"`
+	NonPersonalizedAds bool                   `json:"non_personalized_ads,omitempty"`
+
+	Events []mpEvent `json:"events"`
+}
+
+func validateMPPayload(p mpPayload) error {
+	if p.MeasurementID == "" {
+		return errors.New("measurement_id is required")
+	}
+	if p.APISecret == "" {
+		return errors.New("api_secret is required")
+	}
+	if p.ClientID == "" {
+		return errors.New("client_id is required")
+	}
+	if len(p.Events) == 0 {
+		return errors.New("events must contain at least one event")
+	}
+	for _, e := range p.Events {
+		if e.Name == "" {
+			return errors.New("every event requires a name")
+		}
+	}
+	return nil
+}
+
+// mpForwardRequest is one enriched payload ready to POST upstream.
+type mpForwardRequest struct {
+	body  []byte
+	query string
+}
+
+// mpForwarder sends Measurement Protocol hits upstream, either synchronously
+// or, when buffered, via a bounded channel drained by a background
+// goroutine so transient upstream errors don't drop hits from the caller's
+// perspective.
+type mpForwarder struct {
+	queue      chan mpForwardRequest
+	maxRetries int
+}
+
+// newMPForwarder builds an mpForwarder. bufferSize 0 disables the background
+// queue; forwardWithRetry must be called directly instead.
+func newMPForwarder(bufferSize, maxRetries int) *mpForwarder {
+	f := &mpForwarder{maxRetries: maxRetries}
+	if bufferSize > 0 {
+		f.queue = make(chan mpForwardRequest, bufferSize)
+		go f.run()
+	}
+	return f
+}
+
+func (f *mpForwarder) buffered() bool {
+	return f.queue != nil
+}
+
+// enqueue adds req to the background queue, returning false if it's full.
+func (f *mpForwarder) enqueue(req mpForwardRequest) bool {
+	select {
+	case f.queue <- req:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *mpForwarder) run() {
+	for req := range f.queue {
+		if _, err := f.forwardWithRetry(req); err != nil {
+			log.Printf("mp: dropping batch after %d retries: %v", f.maxRetries, err)
+		}
+	}
+}
+
+// forwardWithRetry POSTs req upstream, retrying with exponential backoff on
+// 5xx responses or transport errors. Used by both the buffered queue and the
+// synchronous path, so a hit is never forwarded without retry just because
+// the caller didn't configure a buffer.
+func (f *mpForwarder) forwardWithRetry(req mpForwardRequest) (int, error) {
+	backoff := 500 * time.Millisecond
+
+	var lastStatus int
+	var lastErr error
+	for attempt := 0; attempt <= f.maxRetries; attempt++ {
+		status, err := forwardMPRequest(req)
+		if err == nil && status < 500 {
+			return status, nil
+		}
+		lastStatus = status
+		if err != nil {
+			lastErr = err
+		} else {
+			lastErr = fmt.Errorf("upstream returned status %d", status)
+		}
+
+		if attempt == f.maxRetries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	return lastStatus, lastErr
+}
+
+// forwardMPRequest POSTs req to the Google Analytics Measurement Protocol
+// endpoint and returns the upstream status code.
+func forwardMPRequest(req mpForwardRequest) (int, error) {
+	upstreamReq := fasthttp.AcquireRequest()
+	upstreamResp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseRequest(upstreamReq)
+	defer fasthttp.ReleaseResponse(upstreamResp)
+
+	upstreamReq.Header.SetMethod(fasthttp.MethodPost)
+	upstreamReq.Header.SetContentType("application/json")
+	upstreamReq.SetRequestURI(measurementProtocolUpstream + "?" + req.query)
+	upstreamReq.SetBody(req.body)
+
+	if err := proxyClient.Do(upstreamReq, upstreamResp); err != nil {
+		return 0, err
+	}
+
+	return upstreamResp.StatusCode(), nil
+}
+
+// measurementProtocolHandler accepts a GA4 Measurement Protocol payload,
+// enriches every event's params with the real client IP/UA (the upstream
+// request is made by the gaxy server itself, so without this every hit would
+// otherwise be attributed to gaxy's own address) plus any configured
+// header->param mappings, and forwards it to Google, either synchronously
+// or via forwarder's background queue.
+func measurementProtocolHandler(forwarder *mpForwarder) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		config := c.Locals("config").(Config)
+
+		var payload mpPayload
+		if err := json.Unmarshal(c.Body(), &payload); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid JSON payload")
+		}
+		if err := validateMPPayload(payload); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		headerParams := injectParamsFromHeaders(config.InjectParamsFromReqHeaders, c)
+		for i := range payload.Events {
+			if payload.Events[i].Params == nil {
+				payload.Events[i].Params = map[string]interface{}{}
+			}
+			payload.Events[i].Params["uip"] = c.IP()
+			payload.Events[i].Params["ua"] = c.Get("User-Agent")
+			for name, val := range headerParams {
+				payload.Events[i].Params[name] = val
+			}
+		}
+
+		body, err := json.Marshal(struct {
+			ClientID string `json:"client_id"`
+
+			UserID             string                 `json:"user_id,omitempty"`
+			TimestampMicros    int64                  `json:"timestamp_micros,omitempty"`
+			UserProperties     map[string]interface{} `json:"user_properties,omitempty"`
+			Consent            map[string]interface{} `json:"consent,omitempty"`
+			NonPersonalizedAds bool                   `json:"non_personalized_ads,omitempty"`
+
+			Events []mpEvent `json:"events"`
+		}{
+			ClientID:           payload.ClientID,
+			UserID:             payload.UserID,
+			TimestampMicros:    payload.TimestampMicros,
+			UserProperties:     payload.UserProperties,
+			Consent:            payload.Consent,
+			NonPersonalizedAds: payload.NonPersonalizedAds,
+			Events:             payload.Events,
+		})
+		if err != nil {
+			return err
+		}
+
+		query := url.Values{
+			"measurement_id": {payload.MeasurementID},
+			"api_secret":     {payload.APISecret},
+		}.Encode()
+		req := mpForwardRequest{body: body, query: query}
+
+		if forwarder.buffered() {
+			if !forwarder.enqueue(req) {
+				return fiber.NewError(fiber.StatusServiceUnavailable, "measurement protocol buffer full")
+			}
+			return c.SendStatus(fiber.StatusAccepted)
+		}
+
+		status, err := forwarder.forwardWithRetry(req)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadGateway, err.Error())
+		}
+		return c.SendStatus(status)
+	}
+}
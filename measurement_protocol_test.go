@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func validMPPayload() mpPayload {
+	return mpPayload{
+		MeasurementID: "G-XXXXXXX",
+		APISecret:     "secret",
+		ClientID:      "client-1",
+		Events:        []mpEvent{{Name: "page_view"}},
+	}
+}
+
+func TestValidateMPPayloadValid(t *testing.T) {
+	if err := validateMPPayload(validMPPayload()); err != nil {
+		t.Fatalf("expected valid payload to pass, got %v", err)
+	}
+}
+
+func TestValidateMPPayloadMissingFields(t *testing.T) {
+	cases := []struct {
+		name   string
+		mutate func(p *mpPayload)
+	}{
+		{"missing measurement_id", func(p *mpPayload) { p.MeasurementID = "" }},
+		{"missing api_secret", func(p *mpPayload) { p.APISecret = "" }},
+		{"missing client_id", func(p *mpPayload) { p.ClientID = "" }},
+		{"no events", func(p *mpPayload) { p.Events = nil }},
+		{"event missing name", func(p *mpPayload) { p.Events = []mpEvent{{}} }},
+	}
+
+	for _, c := range cases {
+		p := validMPPayload()
+		c.mutate(&p)
+		if err := validateMPPayload(p); err == nil {
+			t.Errorf("%s: expected validation error, got nil", c.name)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gaxy_requests_total",
+		Help: "Total number of proxied requests.",
+	}, []string{"route", "status", "upstream"})
+
+	upstreamDurationSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gaxy_upstream_duration_seconds",
+		Help:    "Time spent waiting on the upstream response.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"upstream"})
+
+	bodyBytesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gaxy_body_bytes_total",
+		Help: "Total bytes transferred, by direction (request/response).",
+	}, []string{"direction"})
+
+	bodyRewritesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gaxy_body_rewrites_total",
+		Help: "Total number of response bodies rewritten, by content type.",
+	}, []string{"content_type"})
+
+	upstreamErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gaxy_upstream_errors_total",
+		Help: "Total number of errors making requests to an upstream.",
+	}, []string{"upstream"})
+)
+
+// observeUpstreamDuration records how long an upstream call to `upstream`
+// took, measured from start.
+func observeUpstreamDuration(upstream string, start time.Time) {
+	upstreamDurationSeconds.WithLabelValues(upstream).Observe(time.Since(start).Seconds())
+}
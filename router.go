@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// Site describes one proxied destination: the matchers that select it for
+// an inbound request, the upstream(s) to forward to, and the transformer
+// pipeline applied to the request/response.
+type Site struct {
+	Name         string        `json:"name"`
+	Matchers     []Matcher     `json:"matchers"`
+	Upstreams    []string      `json:"upstreams"`
+	Transformers []Transformer `json:"transformers"`
+}
+
+// Upstream returns the upstream to forward to. Only the first entry is used
+// today; later entries exist so configs can list fallbacks once failover is
+// implemented.
+func (s Site) Upstream() string {
+	if len(s.Upstreams) == 0 {
+		return ""
+	}
+	return s.Upstreams[0]
+}
+
+// Matcher selects a Site for an inbound request. A Matcher matches when all
+// of its non-empty fields match; a Site matches when any of its Matchers
+// matches.
+type Matcher struct {
+	Host       string `json:"host,omitempty"`
+	PathPrefix string `json:"pathPrefix,omitempty"`
+	PathRegex  string `json:"pathRegex,omitempty"`
+
+	// compiledPathRegex is PathRegex compiled once by compileSites, rather
+	// than on every matches() call.
+	compiledPathRegex *regexp.Regexp
+}
+
+// compile precompiles PathRegex, so matches() never has to.
+func (m *Matcher) compile() error {
+	if m.PathRegex == "" {
+		return nil
+	}
+	re, err := regexp.Compile(m.PathRegex)
+	if err != nil {
+		return fmt.Errorf("invalid pathRegex %q: %w", m.PathRegex, err)
+	}
+	m.compiledPathRegex = re
+	return nil
+}
+
+func (m Matcher) matches(host, path string) bool {
+	if m.Host != "" && !matchesHostPattern(m.Host, host) {
+		return false
+	}
+	if m.PathPrefix != "" && !strings.HasPrefix(path, m.PathPrefix) {
+		return false
+	}
+	if m.PathRegex != "" && !m.compiledPathRegex.MatchString(path) {
+		return false
+	}
+	return true
+}
+
+// matchesHostPattern matches host against pattern, which is either an exact
+// host or a "*.suffix" wildcard matching any subdomain of suffix, but not
+// suffix itself (consistent with hostTrie.insertWildcard).
+func matchesHostPattern(pattern, host string) bool {
+	if len(pattern) > 2 && pattern[:2] == "*." {
+		suffix := pattern[2:]
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return pattern == host
+}
+
+// Transformer is one step of a Site's pipeline. Type selects which fields
+// are used: replacePath/replaceHost/addHeader/stripPrefix run against the
+// upstream request, replaceBody runs against the upstream response body.
+type Transformer struct {
+	Type string `json:"type"`
+
+	// replacePath, replaceBody
+	Pattern     string `json:"pattern,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+
+	// replaceHost, addHeader
+	Value string `json:"value,omitempty"`
+
+	// addHeader
+	Header string `json:"header,omitempty"`
+
+	// stripPrefix
+	Prefix string `json:"prefix,omitempty"`
+
+	// compiledPattern is Pattern compiled once by compileSites, for
+	// replacePath/replaceBody.
+	compiledPattern *regexp.Regexp
+}
+
+// compile precompiles Pattern for replacePath/replaceBody transformers, so
+// applyRequestTransformers/applyBodyTransformers never have to.
+func (t *Transformer) compile() error {
+	if t.Type != "replacePath" && t.Type != "replaceBody" {
+		return nil
+	}
+	re, err := regexp.Compile(t.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid %s pattern %q: %w", t.Type, t.Pattern, err)
+	}
+	t.compiledPattern = re
+	return nil
+}
+
+// Router matches inbound requests to a Site, first match wins.
+type Router struct {
+	sites []Site
+}
+
+// NewRouter builds a Router from a list of Sites, in priority order. sites
+// must already have gone through compileSites.
+func NewRouter(sites []Site) *Router {
+	return &Router{sites: sites}
+}
+
+// compileSites precompiles every Matcher/Transformer regex across sites, so
+// routing and transforming never compile on the hot path, and a malformed
+// pathRegex/pattern fails at config load instead of as a 500 on first use.
+func compileSites(sites []Site) error {
+	for i := range sites {
+		for j := range sites[i].Matchers {
+			if err := sites[i].Matchers[j].compile(); err != nil {
+				return fmt.Errorf("site %q: %w", sites[i].Name, err)
+			}
+		}
+		for j := range sites[i].Transformers {
+			if err := sites[i].Transformers[j].compile(); err != nil {
+				return fmt.Errorf("site %q: %w", sites[i].Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Match returns the first Site whose Matchers accept host/path, or nil.
+func (r *Router) Match(host, path string) *Site {
+	for i := range r.sites {
+		site := &r.sites[i]
+		for _, m := range site.Matchers {
+			if m.matches(host, path) {
+				return site
+			}
+		}
+	}
+	return nil
+}
+
+// applyRequestTransformers runs a Site's request-side transformers against
+// the outgoing upstream request, in order.
+func applyRequestTransformers(site Site, upstreamReq *fasthttp.Request) {
+	for _, t := range site.Transformers {
+		switch t.Type {
+		case "stripPrefix":
+			if t.Prefix == "" {
+				continue
+			}
+			path := string(upstreamReq.URI().Path())
+			if strings.HasPrefix(path, t.Prefix) {
+				upstreamReq.URI().SetPath(strings.TrimPrefix(path, t.Prefix))
+			}
+		case "replacePath":
+			path := string(upstreamReq.URI().Path())
+			upstreamReq.URI().SetPath(t.compiledPattern.ReplaceAllString(path, t.Replacement))
+		case "replaceHost":
+			upstreamReq.SetHost(t.Value)
+		case "addHeader":
+			upstreamReq.Header.Set(t.Header, t.Value)
+		}
+	}
+}
+
+// applyBodyTransformers runs a Site's replaceBody transformers against the
+// response body, substituting the "{{host}}" macro with the current gaxy
+// host (plus route prefix) so rewritten URLs point back at this proxy.
+func applyBodyTransformers(site Site, body string, c *fiber.Ctx, routePrefix string) string {
+	currentHost := getGaxyHostName(c) + routePrefix
+
+	for _, t := range site.Transformers {
+		if t.Type != "replaceBody" {
+			continue
+		}
+		replacement := strings.ReplaceAll(t.Replacement, "{{host}}", currentHost)
+		body = t.compiledPattern.ReplaceAllString(body, replacement)
+	}
+
+	return body
+}
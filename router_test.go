@@ -0,0 +1,86 @@
+package main
+
+import "testing"
+
+func TestRouterMatch(t *testing.T) {
+	sites := []Site{
+		{
+			Name:      "ga-collect",
+			Matchers:  []Matcher{{PathPrefix: "/g/collect"}},
+			Upstreams: []string{"https://collect.example.com"},
+		},
+		{
+			Name:      "admin-host",
+			Matchers:  []Matcher{{Host: "admin.example.com"}},
+			Upstreams: []string{"https://admin.example.com"},
+		},
+		{
+			Name:      "default",
+			Matchers:  []Matcher{{PathPrefix: "/"}},
+			Upstreams: []string{"https://default.example.com"},
+		},
+	}
+	if err := compileSites(sites); err != nil {
+		t.Fatalf("compileSites: %v", err)
+	}
+	router := NewRouter(sites)
+
+	cases := []struct {
+		host, path, wantSite string
+	}{
+		{"gaxy.example.com", "/g/collect", "ga-collect"},
+		{"admin.example.com", "/anything", "admin-host"},
+		{"gaxy.example.com", "/other", "default"},
+	}
+
+	for _, c := range cases {
+		site := router.Match(c.host, c.path)
+		if site == nil {
+			t.Errorf("Match(%q, %q) = nil, want %q", c.host, c.path, c.wantSite)
+			continue
+		}
+		if site.Name != c.wantSite {
+			t.Errorf("Match(%q, %q) = %q, want %q", c.host, c.path, site.Name, c.wantSite)
+		}
+	}
+}
+
+func TestRouterMatchNoMatch(t *testing.T) {
+	sites := []Site{
+		{Name: "only", Matchers: []Matcher{{PathPrefix: "/g/collect"}}, Upstreams: []string{"https://example.com"}},
+	}
+	if err := compileSites(sites); err != nil {
+		t.Fatalf("compileSites: %v", err)
+	}
+	router := NewRouter(sites)
+
+	if site := router.Match("gaxy.example.com", "/other"); site != nil {
+		t.Fatalf("expected no match, got %q", site.Name)
+	}
+}
+
+func TestRouterMatchPathRegex(t *testing.T) {
+	sites := []Site{
+		{Name: "regex", Matchers: []Matcher{{PathRegex: `^/g/[a-z]+$`}}, Upstreams: []string{"https://example.com"}},
+	}
+	if err := compileSites(sites); err != nil {
+		t.Fatalf("compileSites: %v", err)
+	}
+	router := NewRouter(sites)
+
+	if site := router.Match("gaxy.example.com", "/g/collect"); site == nil {
+		t.Fatal("expected pathRegex to match")
+	}
+	if site := router.Match("gaxy.example.com", "/g/123"); site != nil {
+		t.Fatal("expected pathRegex not to match")
+	}
+}
+
+func TestCompileSitesInvalidPathRegex(t *testing.T) {
+	sites := []Site{
+		{Name: "bad", Matchers: []Matcher{{PathRegex: "("}}},
+	}
+	if err := compileSites(sites); err == nil {
+		t.Fatal("expected compileSites to fail on invalid pathRegex")
+	}
+}
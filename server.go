@@ -5,12 +5,16 @@ import (
 	"log"
 	"net/url"
 	"reflect"
+	"strconv"
 	"strings"
+	"time"
 	"unsafe"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/valyala/fasthttp"
 )
 
@@ -29,9 +33,14 @@ func main() {
 func Setup(config Config) *fiber.App {
 	app := fiber.New()
 
+	router := NewRouter(config.Sites)
+	hostOverrides := newHostOverrideMatcher(config.HostOverrides)
+
 	// Config object
 	app.Use(func(c *fiber.Ctx) error {
 		c.Locals("config", config)
+		c.Locals("router", router)
+		c.Locals("hostOverrides", hostOverrides)
 		return c.Next()
 	})
 
@@ -41,6 +50,38 @@ func Setup(config Config) *fiber.App {
 	// Logger
 	app.Use(logger.New())
 
+	// A scraper authenticates to /metrics via MetricsAllowIPs, not an access
+	// token, so exempt it from gating rather than also requiring a token.
+	if config.MetricsEnabled {
+		config.AccessAllowedPaths = append(config.AccessAllowedPaths, config.MetricsPath)
+	}
+
+	// Access-token gating
+	if len(config.AccessTokens) > 0 || config.AccessHMACSecret != "" {
+		app.Use(accessTokenMiddleware(config))
+	}
+
+	// Metrics endpoint
+	if config.MetricsEnabled {
+		app.Get(config.MetricsPath, metricsAllowList(config.MetricsAllowIPs), adaptor.HTTPHandler(promhttp.Handler()))
+	}
+
+	// Static routes, checked before the catch-all proxy handler
+	for _, route := range config.StaticRoutes {
+		app.Static(route.Prefix, route.Root, fiber.Static{
+			Index:         route.Index,
+			CacheDuration: -1,
+			MaxAge:        cacheControlMaxAge(route.CacheControl),
+			ByteRange:     route.ByteRange,
+		})
+	}
+
+	// Server-side GA4 Measurement Protocol endpoint
+	if config.MeasurementProtocolEnabled {
+		forwarder := newMPForwarder(config.MeasurementProtocolBufferSize, config.MeasurementProtocolMaxRetries)
+		app.Post(config.MeasurementProtocolPath, measurementProtocolHandler(forwarder))
+	}
+
 	// Handler
 	if config.RoutePrefix != "" {
 		subRoute := app.Group(config.RoutePrefix)
@@ -58,9 +99,55 @@ func pingHandler(c *fiber.Ctx) error {
 	return c.Send([]byte("pong"))
 }
 
-// Given a request send it to the appropriate url
+// metricsAllowList rejects scrape requests from IPs not in allowIPs. An
+// empty allowIPs allows any IP, since restricting access is optional.
+func metricsAllowList(allowIPs []string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if len(allowIPs) == 0 {
+			return c.Next()
+		}
+		for _, ip := range allowIPs {
+			if c.IP() == ip {
+				return c.Next()
+			}
+		}
+		return fiber.NewError(fiber.StatusForbidden, "IP not allowed to scrape metrics")
+	}
+}
+
+// cacheControlMaxAge parses a StaticRoute's CacheControl (a max-age in
+// seconds, e.g. "3600") into the value fiber.Static expects, defaulting to 0
+// (no caching) when unset or invalid.
+func cacheControlMaxAge(cacheControl string) int {
+	if cacheControl == "" {
+		return 0
+	}
+	maxAge, err := strconv.Atoi(cacheControl)
+	if err != nil {
+		return 0
+	}
+	return maxAge
+}
+
+// Given a request send it to the appropriate site's upstream
 func handleRequestAndRedirect(c *fiber.Ctx) error {
 	config := c.Locals("config").(Config)
+	router := c.Locals("router").(*Router)
+	hostOverrides := c.Locals("hostOverrides").(*hostOverrideMatcher)
+
+	host := getGaxyHostName(c)
+	override := hostOverrides.match(host)
+
+	routePrefix := config.RoutePrefix
+	injectParamsFromReqHeaders := config.InjectParamsFromReqHeaders
+	if override != nil {
+		if override.RoutePrefix != "" {
+			routePrefix = override.RoutePrefix
+		}
+		if override.InjectParamsFromReqHeaders != "" {
+			injectParamsFromReqHeaders = override.InjectParamsFromReqHeaders
+		}
+	}
 
 	upstreamReq := fasthttp.AcquireRequest()
 	upstreamResp := fasthttp.AcquireResponse()
@@ -72,66 +159,65 @@ func handleRequestAndRedirect(c *fiber.Ctx) error {
 
 	// Trim prefix
 	reqURI := string(c.Request().RequestURI())
-	if config.RoutePrefix != "" && strings.HasPrefix(reqURI, config.RoutePrefix+"/") {
-		reqURI = strings.TrimPrefix(reqURI, config.RoutePrefix)
+	if routePrefix != "" && strings.HasPrefix(reqURI, routePrefix+"/") {
+		reqURI = strings.TrimPrefix(reqURI, routePrefix)
 		upstreamReq.SetRequestURI(reqURI)
 	}
 
-	// [WORKAROUND] To "easily" manage multiple Google domains, set this hard condition
-	var targetOrigin string
-	if strings.HasPrefix(reqURI, "/g/collect") {
-		targetOrigin = "https://www.google-analytics.com"
-	} else {
-		targetOrigin = config.GoogleOrigin
+	site := router.Match(host, string(upstreamReq.URI().Path()))
+	if site == nil {
+		return fiber.NewError(fiber.StatusNotFound, "no site matches this request")
 	}
 
 	// Overwrite
-	url, _ := url.Parse(targetOrigin)
+	upstream := site.Upstream()
+	if override != nil && override.GoogleOrigin != "" {
+		upstream = override.GoogleOrigin
+	}
+	url, _ := url.Parse(upstream)
 	upstreamReq.SetHost(url.Host)
 	upstreamReq.URI().SetScheme(url.Scheme)
 
+	applyRequestTransformers(*site, upstreamReq)
+
 	// Prepare request
-	prepareRequest(upstreamReq, c)
+	prepareRequest(upstreamReq, c, injectParamsFromReqHeaders, config.SkipParamsFromReqHeaders)
 	log.Printf("GET %s -> making request to %s", c.Params("*"), upstreamReq.URI().FullURI())
 
 	// Start request to dest URL
-	if err := proxyClient.Do(upstreamReq, upstreamResp); err != nil {
+	start := time.Now()
+	err := proxyClient.Do(upstreamReq, upstreamResp)
+	observeUpstreamDuration(upstream, start)
+	if err != nil {
+		upstreamErrorsTotal.WithLabelValues(upstream).Inc()
 		return err
 	}
 
+	bodyBytesTotal.WithLabelValues("request").Add(float64(len(upstreamReq.Body())))
+
 	// Post process the response
-	if err := postprocessResponse(upstreamResp, c); err != nil {
+	if err := postprocessResponse(upstreamResp, c, *site, routePrefix); err != nil {
 		return err
 	}
 
+	requestsTotal.WithLabelValues(c.Route().Path, strconv.Itoa(c.Response().StatusCode()), upstream).Inc()
+
 	return nil
 }
 
 // Prepare request
-func prepareRequest(upstreamResp *fasthttp.Request, c *fiber.Ctx) {
-	config := c.Locals("config").(Config)
-
-	for _, name := range strings.Split(config.InjectParamsFromReqHeaders, ",") {
-		// Convert header fields to request params
-		// e.g. INJECT_PARAMS_FROM_REQ_HEADERS=uip,user-agent
-		//   will be add this to the URI: ?uip=[VALUE]&user-agent=[VALUE]
-		// To rename the key, use [HEADER_NAME]__[NEW_NAME]
-		// e.g. INJECT_PARAMS_FROM_REQ_HEADERS=x-email__uip,user-agent__ua
-		if name != "" {
-			if strings.Contains(name, "__") {
-				ss := strings.Split(name, "__")
-				val := c.Get(ss[0])
-				upstreamResp.URI().QueryArgs().Add(ss[1], val)
-				log.Printf("Added %s=%s to query string\n", ss[1], val)
-			} else {
-				val := c.Get(name)
-				upstreamResp.URI().QueryArgs().Add(name, val)
-				log.Printf("Added %s=%s to query string\n", name, val)
-			}
-		}
+func prepareRequest(upstreamResp *fasthttp.Request, c *fiber.Ctx, injectParamsFromReqHeaders, skipParamsFromReqHeaders string) {
+	// Convert header fields to request params
+	// e.g. INJECT_PARAMS_FROM_REQ_HEADERS=uip,user-agent
+	//   will be add this to the URI: ?uip=[VALUE]&user-agent=[VALUE]
+	// To rename the key, use [HEADER_NAME]__[NEW_NAME]
+	// e.g. INJECT_PARAMS_FROM_REQ_HEADERS=x-email__uip,user-agent__ua
+	for name, val := range injectParamsFromHeaders(injectParamsFromReqHeaders, c) {
+		upstreamResp.URI().QueryArgs().Add(name, val)
+		log.Printf("Added %s=%s to query string\n", name, val)
 	}
 
-	for _, name := range strings.Split(config.SkipParamsFromReqHeaders, ",") {
+	for _, name := range strings.Split(skipParamsFromReqHeaders, ",") {
 		// Skip params from original request
 		if name != "" {
 			upstreamResp.URI().QueryArgs().Del(name)
@@ -144,10 +230,29 @@ func prepareRequest(upstreamResp *fasthttp.Request, c *fiber.Ctx) {
 	upstreamResp.URI().QueryArgs().Add("ua", c.Get("User-Agent"))
 }
 
-// Post process response
-func postprocessResponse(upstreamResp *fasthttp.Response, c *fiber.Ctx) error {
-	config := c.Locals("config").(Config)
+// injectParamsFromHeaders parses an INJECT_PARAMS_FROM_REQ_HEADERS-style spec
+// (see prepareRequest) into a map of param name to header value, so it can
+// be reused anywhere request headers need mapping to output params.
+func injectParamsFromHeaders(spec string, c *fiber.Ctx) map[string]string {
+	params := map[string]string{}
+
+	for _, name := range strings.Split(spec, ",") {
+		if name == "" {
+			continue
+		}
+		if strings.Contains(name, "__") {
+			ss := strings.Split(name, "__")
+			params[ss[1]] = c.Get(ss[0])
+		} else {
+			params[name] = c.Get(name)
+		}
+	}
+
+	return params
+}
 
+// Post process response
+func postprocessResponse(upstreamResp *fasthttp.Response, c *fiber.Ctx, site Site, routePrefix string) error {
 	// Add header
 	upstreamResp.Header.Add("x-proxy-by", "gaxy")
 
@@ -158,22 +263,12 @@ func postprocessResponse(upstreamResp *fasthttp.Response, c *fiber.Ctx) error {
 
 	var contentType = string(upstreamResp.Header.ContentType())
 	if strings.HasPrefix(contentType, "text/javascript") || strings.HasPrefix(contentType, "application/javascript") {
-		find := []string{
-			"\"+(a?a+\".\":\"\")+\"analytics.google.com",
-			"ssl.google-analytics.com",
-			"\"+a+\".google-analytics.com",
-			"www.google-analytics.com",
-			"google-analytics.com",
-			"www.googletagmanager.com",
-			"googletagmanager.com",
-		}
-		currentHost := getGaxyHostName(c)
-
-		for _, toReplace := range find {
-			bodyString = strings.ReplaceAll(bodyString, toReplace, currentHost+config.RoutePrefix)
-		}
+		bodyString = applyBodyTransformers(site, bodyString, c, routePrefix)
+		bodyRewritesTotal.WithLabelValues(contentType).Inc()
 	}
 
+	bodyBytesTotal.WithLabelValues("response").Add(float64(len(bodyString)))
+
 	c.Response().SetBodyString(bodyString)
 	c.Response().Header.SetContentType(string(upstreamResp.Header.ContentType()))
 	c.Response().SetStatusCode(upstreamResp.StatusCode())